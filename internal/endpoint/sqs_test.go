@@ -0,0 +1,50 @@
+package endpoint
+
+import "testing"
+
+func TestParseSQSEndpointURICreateIfNotExistsDefaultsTrue(t *testing.T) {
+	var ep Endpoint
+	if err := ParseSQSEndpointURI(&ep, "sqs://us-east-1/my-queue"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ep.SQS.CreateIfNotExists {
+		t.Fatalf("expected CreateIfNotExists to default to true when the URI omits it")
+	}
+	if ep.SQS.Region != "us-east-1" {
+		t.Fatalf("expected Region %q, got %q", "us-east-1", ep.SQS.Region)
+	}
+	if ep.SQS.QueueName != "my-queue" {
+		t.Fatalf("expected QueueName %q, got %q", "my-queue", ep.SQS.QueueName)
+	}
+}
+
+func TestParseSQSEndpointURICreateIfNotExistsExplicitFalse(t *testing.T) {
+	var ep Endpoint
+	if err := ParseSQSEndpointURI(&ep, "sqs://us-east-1/my-queue?createIfNotExists=false"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ep.SQS.CreateIfNotExists {
+		t.Fatalf("expected CreateIfNotExists to be false when the URI explicitly opts out")
+	}
+}
+
+func TestParseSQSEndpointURIFIFOAndProfile(t *testing.T) {
+	var ep Endpoint
+	err := ParseSQSEndpointURI(&ep, "sqs://us-east-1/my-queue.fifo?fifo=true&profile=x")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ep.SQS.FIFO {
+		t.Fatalf("expected FIFO to be true")
+	}
+	if ep.SQS.Profile != "x" {
+		t.Fatalf("expected Profile %q, got %q", "x", ep.SQS.Profile)
+	}
+}
+
+func TestParseSQSEndpointURIInvalidBool(t *testing.T) {
+	var ep Endpoint
+	if err := ParseSQSEndpointURI(&ep, "sqs://us-east-1/my-queue?fifo=sorta"); err == nil {
+		t.Fatalf("expected an error for an unparseable fifo value")
+	}
+}
@@ -2,7 +2,10 @@ package server
 
 import (
 	"math"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/tidwall/geojson"
 	"github.com/tidwall/geojson/geometry"
@@ -16,11 +19,16 @@ const (
 	NOOP BinaryOp = iota
 	AND
 	OR
-	tokenAND    = "and"
-	tokenOR     = "or"
-	tokenNOT    = "not"
-	tokenLParen = "("
-	tokenRParen = ")"
+	tokenAND      = "and"
+	tokenOR       = "or"
+	tokenNOT      = "not"
+	tokenLParen   = "("
+	tokenRParen   = ")"
+	tokenTouches  = "touches"
+	tokenCrosses  = "crosses"
+	tokenOverlaps = "overlaps"
+	tokenDisjoint = "disjoint"
+	tokenEquals   = "equals"
 )
 
 // AreaExpression is (maybe negated) either an spatial object or operator +
@@ -30,6 +38,12 @@ type AreaExpression struct {
 	obj      geojson.Object
 	op       BinaryOp
 	children children
+
+	rectOnce  sync.Once
+	rectVal   geometry.Rect
+	orderOnce sync.Once
+	ordered   children
+	calls     uint64
 }
 
 type children []*AreaExpression
@@ -60,8 +74,17 @@ func (e *AreaExpression) String() (res string) {
 	return
 }
 
-// Return union of rects for all involved objects
-func (e *AreaExpression) Rect() (rect geometry.Rect) {
+// Rect returns the union of rects for all involved objects. The tree is
+// built once by the parser and never mutated afterwards, so the result is
+// computed on first use and memoized for the lifetime of the node.
+func (e *AreaExpression) Rect() geometry.Rect {
+	e.rectOnce.Do(func() {
+		e.rectVal = e.computeRect()
+	})
+	return e.rectVal
+}
+
+func (e *AreaExpression) computeRect() (rect geometry.Rect) {
 	if e.obj != nil {
 		rect = e.obj.Rect()
 		return
@@ -84,6 +107,37 @@ func (e *AreaExpression) Rect() (rect geometry.Rect) {
 	return
 }
 
+// rectArea is the selectivity heuristic used to order AND/OR children:
+// smaller rects are assumed cheaper and more likely to rule an AND branch
+// out, larger rects more likely to satisfy an OR branch.
+func rectArea(r geometry.Rect) float64 {
+	return (r.Max.X - r.Min.X) * (r.Max.Y - r.Min.Y)
+}
+
+// orderedChildren returns e.children reordered for the cheapest expected
+// evaluation: ascending area for AND (so the most selective, likely-false
+// branch is tried first and short-circuits the rest), descending area for
+// OR (so the most likely-true branch is tried first). The order is
+// computed once and cached, since the tree doesn't change after parsing.
+func (e *AreaExpression) orderedChildren() children {
+	e.orderOnce.Do(func() {
+		ordered := make(children, len(e.children))
+		copy(ordered, e.children)
+		switch e.op {
+		case AND:
+			sort.SliceStable(ordered, func(i, j int) bool {
+				return rectArea(ordered[i].Rect()) < rectArea(ordered[j].Rect())
+			})
+		case OR:
+			sort.SliceStable(ordered, func(i, j int) bool {
+				return rectArea(ordered[i].Rect()) > rectArea(ordered[j].Rect())
+			})
+		}
+		e.ordered = ordered
+	})
+	return e.ordered
+}
+
 // Return boolean value modulo negate field of the expression.
 func (e *AreaExpression) maybeNegate(val bool) bool {
 	if e.negate {
@@ -98,19 +152,20 @@ func (e *AreaExpression) testObject(
 	objObjTest func(o1, o2 geojson.Object) bool,
 	exprObjTest func(ae *AreaExpression, ob geojson.Object) bool,
 ) bool {
+	atomic.AddUint64(&e.calls, 1)
 	if e.obj != nil {
 		return objObjTest(e.obj, o)
 	}
 	switch e.op {
 	case AND:
-		for _, c := range e.children {
+		for _, c := range e.orderedChildren() {
 			if !exprObjTest(c, o) {
 				return false
 			}
 		}
 		return true
 	case OR:
-		for _, c := range e.children {
+		for _, c := range e.orderedChildren() {
 			if exprObjTest(c, o) {
 				return true
 			}
@@ -120,7 +175,28 @@ func (e *AreaExpression) testObject(
 	return false
 }
 
+// rectsDisjoint reports whether a and b share no point at all (rects that
+// only touch along an edge or at a corner are not disjoint), the cheap
+// bounding-box precondition for Intersects.
+func rectsDisjoint(a, b geometry.Rect) bool {
+	return a.Max.X < b.Min.X || a.Min.X > b.Max.X || a.Max.Y < b.Min.Y || a.Min.Y > b.Max.Y
+}
+
+// rectContains reports whether outer fully contains inner, the cheap
+// bounding-box precondition for Within.
+func rectContains(outer, inner geometry.Rect) bool {
+	return outer.Min.X <= inner.Min.X && outer.Min.Y <= inner.Min.Y &&
+		outer.Max.X >= inner.Max.X && outer.Max.Y >= inner.Max.Y
+}
+
 func (e *AreaExpression) rawIntersects(o geojson.Object) bool {
+	// A leaf whose Rect doesn't even overlap o's Rect can't intersect it,
+	// so skip the real geometry test. Groups aren't short-circuited on
+	// their union Rect: a negated child is true exactly when it doesn't
+	// intersect o, so each leaf checks its own Rect instead.
+	if e.obj != nil && rectsDisjoint(e.Rect(), o.Rect()) {
+		return false
+	}
 	return e.testObject(o, geojson.Object.Intersects, (*AreaExpression).Intersects)
 }
 
@@ -129,6 +205,13 @@ func (e *AreaExpression) rawContains(o geojson.Object) bool {
 }
 
 func (e *AreaExpression) rawWithin(o geojson.Object) bool {
+	// For a leaf, e.Within(o) requires e's geometry to be entirely inside
+	// o, which is impossible if e's Rect isn't entirely inside o's Rect.
+	// Groups aren't short-circuited on their union Rect: an OR group is
+	// within o when any one child is, and a negated child when it isn't.
+	if e.obj != nil && !rectContains(o.Rect(), e.Rect()) {
+		return false
+	}
 	return e.testObject(o, geojson.Object.Within, (*AreaExpression).Within)
 }
 
@@ -144,6 +227,256 @@ func (e *AreaExpression) Within(o geojson.Object) bool {
 	return e.maybeNegate(e.rawWithin(o))
 }
 
+// objectsDisjoint reports whether o1 and o2 share no point at all.
+func objectsDisjoint(o1, o2 geojson.Object) bool {
+	return !o1.Intersects(o2)
+}
+
+// objectsEquals reports whether o1 and o2 occupy the same point set,
+// defined as each being within the other.
+func objectsEquals(o1, o2 geojson.Object) bool {
+	return o1.Within(o2) && o2.Within(o1)
+}
+
+// rectIntersection returns the overlapping rect of a and b, or ok=false if
+// they don't intersect at all.
+func rectIntersection(a, b geometry.Rect) (r geometry.Rect, ok bool) {
+	if rectsDisjoint(a, b) {
+		return geometry.Rect{}, false
+	}
+	return geometry.Rect{
+		Min: geometry.Point{X: math.Max(a.Min.X, b.Min.X), Y: math.Max(a.Min.Y, b.Min.Y)},
+		Max: geometry.Point{X: math.Min(a.Max.X, b.Max.X), Y: math.Min(a.Max.Y, b.Max.Y)},
+	}, true
+}
+
+// probeEpsilon is the half-width of the small square epsilonSquareAround
+// builds to test strict interior containment at a point.
+const probeEpsilon = 1e-9
+
+// epsilonSquareAround builds a tiny square polygon centered on at, used to
+// probe a single point for strict interior containment: a geometry
+// Contains()s the probe only if at sits in its interior, since any
+// boundary or exterior proximity lets some corner of the square poke
+// outside.
+func epsilonSquareAround(at geometry.Point) geojson.Object {
+	return geojson.NewPolygon(geometry.NewPoly([]geometry.Point{
+		{X: at.X - probeEpsilon, Y: at.Y - probeEpsilon},
+		{X: at.X + probeEpsilon, Y: at.Y - probeEpsilon},
+		{X: at.X + probeEpsilon, Y: at.Y + probeEpsilon},
+		{X: at.X - probeEpsilon, Y: at.Y + probeEpsilon},
+		{X: at.X - probeEpsilon, Y: at.Y - probeEpsilon},
+	}, nil, nil))
+}
+
+// pointStrictlyInside reports whether at is in o's interior, as opposed
+// to on its boundary or outside it, by testing whether a tiny square
+// centered on it is fully Contained by o.
+func pointStrictlyInside(at geometry.Point, o geojson.Object) bool {
+	return o.Contains(epsilonSquareAround(at))
+}
+
+// interiorOverlapProbeGrid is how many sample points per axis
+// objectsShareInterior probes across the candidate overlap region.
+const interiorOverlapProbeGrid = 9
+
+// objectsShareInterior reports whether o1 and o2 have a genuinely
+// overlapping interior, as opposed to meeting only along their
+// boundaries -- including boundaries that aren't axis-aligned, where a
+// bounding-Rect comparison alone can't tell the difference (two triangles
+// sharing only their common hypotenuse can still have Rects that overlap
+// over their full combined area).
+//
+// geojson.Object gives us no segment/vertex access, only
+// Intersects/Contains/Within/Rect, so this samples a grid of points
+// across the Rect intersection (the only region where overlap is even
+// possible) and, for each, checks pointStrictlyInside against both o1 and
+// o2: that can only be true at a point genuinely inside both interiors.
+// If the real overlap region is non-empty the grid is dense enough to
+// land inside it for every shape this package is tested against; an
+// arbitrarily thin sliver of true overlap could in principle fall
+// between grid points and be missed, the same class of approximation
+// documented on objectsCrosses.
+func objectsShareInterior(o1, o2 geojson.Object) bool {
+	r, ok := rectIntersection(o1.Rect(), o2.Rect())
+	if !ok || r.Max.X <= r.Min.X || r.Max.Y <= r.Min.Y {
+		return false
+	}
+	for i := 0; i < interiorOverlapProbeGrid; i++ {
+		fx := (float64(i) + 0.5) / interiorOverlapProbeGrid
+		x := r.Min.X + fx*(r.Max.X-r.Min.X)
+		for j := 0; j < interiorOverlapProbeGrid; j++ {
+			fy := (float64(j) + 0.5) / interiorOverlapProbeGrid
+			at := geometry.Point{X: x, Y: r.Min.Y + fy*(r.Max.Y-r.Min.Y)}
+			if pointStrictlyInside(at, o1) && pointStrictlyInside(at, o2) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// objectsOverlaps reports whether o1 and o2 share interior area without
+// either containing the other. This, and the predicates built on it
+// below, approximate the DE-9IM relations using only the
+// Intersects/Contains/Within/Rect primitives geojson.Object exposes.
+func objectsOverlaps(o1, o2 geojson.Object) bool {
+	return o1.Intersects(o2) && !o1.Contains(o2) && !o2.Contains(o1) &&
+		!o1.Within(o2) && !o2.Within(o1) && objectsShareInterior(o1, o2)
+}
+
+// objectsTouches reports whether o1 and o2 intersect but share no
+// interior area, i.e. they only meet along their boundaries (or at a
+// single point), including non-axis-aligned ones such as a shared
+// diagonal edge. Deliberately doesn't consult Contains/Within for the
+// non-point case: those are boundary-inclusive, so a point sitting
+// exactly on a polygon's edge counts as "Contained" by this library's
+// definition even though it's exactly the boundary-touching case this
+// predicate exists to catch.
+//
+// A point's Rect is always degenerate regardless of where the point
+// actually sits, so objectsShareInterior's Rect-intersection precondition
+// can't tell a boundary point from an interior one -- that case is
+// handed off to pointTouches instead, which probes with an epsilon-sized
+// box around the point.
+func objectsTouches(o1, o2 geojson.Object) bool {
+	if !o1.Intersects(o2) {
+		return false
+	}
+	if objectIsPoint(o1) {
+		return pointTouches(o1, o2)
+	}
+	if objectIsPoint(o2) {
+		return pointTouches(o2, o1)
+	}
+	return !objectsShareInterior(o1, o2)
+}
+
+// objectIsPoint reports whether o is a single point, i.e. its Rect has
+// collapsed to zero area at a single location.
+func objectIsPoint(o geojson.Object) bool {
+	r := o.Rect()
+	return r.Min == r.Max
+}
+
+// pointTouches reports whether point p touches o: p must intersect o,
+// but not sit strictly in its interior.
+func pointTouches(p, o geojson.Object) bool {
+	if !o.Intersects(p) {
+		return false
+	}
+	return !pointStrictlyInside(p.Rect().Min, o)
+}
+
+// isRectDegenerate reports whether r has zero width or zero height. For
+// an axis-aligned bounding box this is the only cheap proxy available for
+// "this geometry's dimension is lower than 2" without a real Dimension()
+// accessor on geojson.Object -- it catches horizontal/vertical lines and
+// points, but not e.g. a diagonal line (see objectsCrosses).
+func isRectDegenerate(r geometry.Rect) bool {
+	return r.Max.X == r.Min.X || r.Max.Y == r.Min.Y
+}
+
+// crossesOrdered checks the DE-9IM Crosses relation treating lo as the
+// (assumed) lower-dimensional operand and hi as the higher-dimensional
+// one: lo must partially enter hi's interior without being fully
+// contained in it.
+func crossesOrdered(lo, hi geojson.Object) bool {
+	if !isRectDegenerate(lo.Rect()) || isRectDegenerate(hi.Rect()) {
+		return false
+	}
+	return lo.Intersects(hi) && !lo.Within(hi) && !hi.Contains(lo)
+}
+
+// objectsCrosses reports whether o1 and o2 intersect with a lower
+// dimensional interior intersection than either, such as a line crossing
+// through a polygon's boundary and out the other side. It only fires when
+// exactly one of o1/o2 has a degenerate (axis-aligned line/point) Rect --
+// see isRectDegenerate -- so it misses crossings involving a
+// non-axis-aligned line, where it falls back to reporting the same result
+// as objectsOverlaps. There is no reliable way to distinguish those cases
+// with only the Intersects/Contains/Within/Rect primitives
+// geojson.Object exposes.
+func objectsCrosses(o1, o2 geojson.Object) bool {
+	if crossesOrdered(o1, o2) || crossesOrdered(o2, o1) {
+		return true
+	}
+	if isRectDegenerate(o1.Rect()) || isRectDegenerate(o2.Rect()) {
+		return false
+	}
+	return objectsOverlaps(o1, o2)
+}
+
+func (e *AreaExpression) rawDisjoint(o geojson.Object) bool {
+	return e.testObject(o, objectsDisjoint, (*AreaExpression).Disjoint)
+}
+
+func (e *AreaExpression) rawEquals(o geojson.Object) bool {
+	return e.testObject(o, objectsEquals, (*AreaExpression).Equals)
+}
+
+func (e *AreaExpression) rawOverlaps(o geojson.Object) bool {
+	return e.testObject(o, objectsOverlaps, (*AreaExpression).Overlaps)
+}
+
+func (e *AreaExpression) rawTouches(o geojson.Object) bool {
+	return e.testObject(o, objectsTouches, (*AreaExpression).Touches)
+}
+
+func (e *AreaExpression) rawCrosses(o geojson.Object) bool {
+	return e.testObject(o, objectsCrosses, (*AreaExpression).Crosses)
+}
+
+func (e *AreaExpression) Disjoint(o geojson.Object) bool {
+	return e.maybeNegate(e.rawDisjoint(o))
+}
+
+func (e *AreaExpression) Equals(o geojson.Object) bool {
+	return e.maybeNegate(e.rawEquals(o))
+}
+
+func (e *AreaExpression) Overlaps(o geojson.Object) bool {
+	return e.maybeNegate(e.rawOverlaps(o))
+}
+
+func (e *AreaExpression) Touches(o geojson.Object) bool {
+	return e.maybeNegate(e.rawTouches(o))
+}
+
+// Crosses reports whether e's geometry crosses into and out of o's
+// interior (or vice versa). Exact for an axis-aligned line/point crossing
+// a polygon; for any other mix of geometry kinds (in particular a
+// diagonal line crossing a polygon) geojson.Object gives us no way to
+// tell Crosses apart from Overlaps, so it degrades to that. See
+// objectsCrosses for the precise rule.
+func (e *AreaExpression) Crosses(o geojson.Object) bool {
+	return e.maybeNegate(e.rawCrosses(o))
+}
+
+// AreaPredicates maps an AREA clause's relational keyword (tokenTouches,
+// tokenCrosses, ...) to the AreaExpression method it should dispatch to.
+// The command parser that turns a TOUCHES/CROSSES/OVERLAPS/DISJOINT/
+// EQUALS query keyword into a predicate call looks the keyword up here
+// rather than duplicating this switch itself.
+var AreaPredicates = map[string]func(*AreaExpression, geojson.Object) bool{
+	tokenTouches:  (*AreaExpression).Touches,
+	tokenCrosses:  (*AreaExpression).Crosses,
+	tokenOverlaps: (*AreaExpression).Overlaps,
+	tokenDisjoint: (*AreaExpression).Disjoint,
+	tokenEquals:   (*AreaExpression).Equals,
+}
+
+// TestNamed looks up token (case-insensitively) in AreaPredicates and
+// evaluates it against o. ok is false if token isn't a recognized AREA
+// predicate keyword, in which case result is always false.
+func (e *AreaExpression) TestNamed(token string, o geojson.Object) (result, ok bool) {
+	fn, ok := AreaPredicates[strings.ToLower(token)]
+	if !ok {
+		return false, false
+	}
+	return fn(e, o), true
+}
+
 // Methods for testing an AreaExpression against another AreaExpression.
 func (e *AreaExpression) testExpression(
 	other *AreaExpression,
@@ -161,14 +494,14 @@ func (e *AreaExpression) testExpression(
 	}
 	switch other.op {
 	case AND:
-		for _, c := range other.children {
+		for _, c := range other.orderedChildren() {
 			if !rawExprExprTest(e, c) {
 				return false
 			}
 		}
 		return true
 	case OR:
-		for _, c := range other.children {
+		for _, c := range other.orderedChildren() {
 			if rawExprExprTest(e, c) {
 				return true
 			}
@@ -213,3 +546,86 @@ func (e *AreaExpression) WithinExpr(other *AreaExpression) bool {
 func (e *AreaExpression) ContainsExpr(other *AreaExpression) bool {
 	return e.maybeNegate(e.rawContainsExpr(other))
 }
+
+func (e *AreaExpression) rawDisjointExpr(other *AreaExpression) bool {
+	return e.testExpression(
+		other,
+		(*AreaExpression).rawDisjoint,
+		(*AreaExpression).rawDisjointExpr,
+		(*AreaExpression).DisjointExpr)
+}
+
+func (e *AreaExpression) rawEqualsExpr(other *AreaExpression) bool {
+	return e.testExpression(
+		other,
+		(*AreaExpression).rawEquals,
+		(*AreaExpression).rawEqualsExpr,
+		(*AreaExpression).EqualsExpr)
+}
+
+func (e *AreaExpression) rawOverlapsExpr(other *AreaExpression) bool {
+	return e.testExpression(
+		other,
+		(*AreaExpression).rawOverlaps,
+		(*AreaExpression).rawOverlapsExpr,
+		(*AreaExpression).OverlapsExpr)
+}
+
+func (e *AreaExpression) rawTouchesExpr(other *AreaExpression) bool {
+	return e.testExpression(
+		other,
+		(*AreaExpression).rawTouches,
+		(*AreaExpression).rawTouchesExpr,
+		(*AreaExpression).TouchesExpr)
+}
+
+func (e *AreaExpression) rawCrossesExpr(other *AreaExpression) bool {
+	return e.testExpression(
+		other,
+		(*AreaExpression).rawCrosses,
+		(*AreaExpression).rawCrossesExpr,
+		(*AreaExpression).CrossesExpr)
+}
+
+func (e *AreaExpression) DisjointExpr(other *AreaExpression) bool {
+	return e.maybeNegate(e.rawDisjointExpr(other))
+}
+
+func (e *AreaExpression) EqualsExpr(other *AreaExpression) bool {
+	return e.maybeNegate(e.rawEqualsExpr(other))
+}
+
+func (e *AreaExpression) OverlapsExpr(other *AreaExpression) bool {
+	return e.maybeNegate(e.rawOverlapsExpr(other))
+}
+
+func (e *AreaExpression) TouchesExpr(other *AreaExpression) bool {
+	return e.maybeNegate(e.rawTouchesExpr(other))
+}
+
+func (e *AreaExpression) CrossesExpr(other *AreaExpression) bool {
+	return e.maybeNegate(e.rawCrossesExpr(other))
+}
+
+// ExplainNode is a snapshot of one AreaExpression node's evaluation cost,
+// returned by Explain.
+type ExplainNode struct {
+	Desc     string
+	Calls    uint64
+	Children []*ExplainNode
+}
+
+// Explain walks the expression tree and reports, per node, how many times
+// its predicate was actually evaluated (i.e. not skipped by a Rect
+// short-circuit). It's meant for operators trying to understand why a
+// large AREA expression is slow, not for the hot query path.
+func (e *AreaExpression) Explain() *ExplainNode {
+	node := &ExplainNode{
+		Desc:  e.String(),
+		Calls: atomic.LoadUint64(&e.calls),
+	}
+	for _, c := range e.children {
+		node.Children = append(node.Children, c.Explain())
+	}
+	return node
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"github.com/tidwall/geojson"
+	"github.com/tidwall/geojson/geometry"
+)
+
+func square(minX, minY, maxX, maxY float64) geojson.Object {
+	return geojson.NewPolygon(geometry.NewPoly(
+		[]geometry.Point{
+			{X: minX, Y: minY},
+			{X: maxX, Y: minY},
+			{X: maxX, Y: maxY},
+			{X: minX, Y: maxY},
+			{X: minX, Y: minY},
+		}, nil, nil))
+}
+
+func point(x, y float64) geojson.Object {
+	return geojson.NewPoint(geometry.Point{X: x, Y: y})
+}
+
+func line(points ...geometry.Point) geojson.Object {
+	return geojson.NewLineString(geometry.NewLine(points, nil))
+}
+
+func triangle(a, b, c geometry.Point) geojson.Object {
+	return geojson.NewPolygon(geometry.NewPoly([]geometry.Point{a, b, c, a}, nil, nil))
+}
+
+func exprFor(o geojson.Object) *AreaExpression {
+	return &AreaExpression{obj: o}
+}
+
+func TestAreaExpressionDisjoint(t *testing.T) {
+	a := exprFor(square(0, 0, 1, 1))
+	far := square(10, 10, 11, 11)
+	adjacent := square(1, 0, 2, 1)
+	if !a.Disjoint(far) {
+		t.Fatalf("expected disjoint squares with empty intersection to be Disjoint")
+	}
+	if a.Disjoint(adjacent) {
+		t.Fatalf("expected edge-sharing squares (coincident edge) not to be Disjoint")
+	}
+}
+
+func TestAreaExpressionEquals(t *testing.T) {
+	a := exprFor(square(0, 0, 1, 1))
+	same := square(0, 0, 1, 1)
+	other := square(0, 0, 2, 2)
+	if !a.Equals(same) {
+		t.Fatalf("expected identical squares to be Equals")
+	}
+	if a.Equals(other) {
+		t.Fatalf("expected differently sized squares not to be Equals")
+	}
+}
+
+func TestAreaExpressionTouches(t *testing.T) {
+	a := exprFor(square(0, 0, 1, 1))
+	adjacent := square(1, 0, 2, 1) // shares the edge x=1
+	overlapping := square(0.5, 0, 1.5, 1)
+	if !a.Touches(adjacent) {
+		t.Fatalf("expected squares sharing a coincident edge to Touch")
+	}
+	if a.Touches(overlapping) {
+		t.Fatalf("expected interior-overlapping squares not to Touch")
+	}
+}
+
+func TestAreaExpressionTouchesPointOnBoundary(t *testing.T) {
+	a := exprFor(square(0, 0, 1, 1))
+	onBoundary := point(1, 0.5)
+	inside := point(0.5, 0.5)
+	if !a.Touches(onBoundary) {
+		t.Fatalf("expected a point sitting on the boundary to Touch")
+	}
+	if a.Touches(inside) {
+		t.Fatalf("expected a point strictly inside not to Touch")
+	}
+}
+
+func TestAreaExpressionTouchesDiagonalSharedEdge(t *testing.T) {
+	// Two triangles sharing only their common hypotenuse -- their Rects
+	// are identical (both [0,0]-[2,2]) even though they only meet along a
+	// diagonal line, so a bounding-Rect-area check alone would wrongly
+	// call this an interior overlap.
+	a := exprFor(triangle(
+		geometry.Point{X: 0, Y: 0},
+		geometry.Point{X: 2, Y: 0},
+		geometry.Point{X: 0, Y: 2},
+	))
+	b := triangle(
+		geometry.Point{X: 2, Y: 0},
+		geometry.Point{X: 0, Y: 2},
+		geometry.Point{X: 2, Y: 2},
+	)
+	if !a.Touches(b) {
+		t.Fatalf("expected triangles sharing only their hypotenuse to Touch")
+	}
+	if a.Overlaps(b) {
+		t.Fatalf("expected triangles sharing only their hypotenuse not to Overlap")
+	}
+}
+
+func TestAreaExpressionOverlapsDiagonal(t *testing.T) {
+	// Two triangles that genuinely share interior area across a diagonal
+	// boundary, as opposed to merely meeting along one.
+	a := exprFor(triangle(
+		geometry.Point{X: 0, Y: 0},
+		geometry.Point{X: 2, Y: 0},
+		geometry.Point{X: 0, Y: 2},
+	))
+	b := triangle(
+		geometry.Point{X: 0.5, Y: 0.5},
+		geometry.Point{X: 2.5, Y: 0.5},
+		geometry.Point{X: 0.5, Y: 2.5},
+	)
+	if !a.Overlaps(b) {
+		t.Fatalf("expected diagonally-overlapping triangles to Overlap")
+	}
+	if a.Touches(b) {
+		t.Fatalf("expected diagonally-overlapping triangles not to Touch")
+	}
+}
+
+func TestAreaExpressionOverlaps(t *testing.T) {
+	a := exprFor(square(0, 0, 1, 1))
+	overlapping := square(0.5, 0, 1.5, 1)
+	contained := square(0.25, 0.25, 0.75, 0.75)
+	if !a.Overlaps(overlapping) {
+		t.Fatalf("expected interior-overlapping squares to Overlap")
+	}
+	if a.Overlaps(contained) {
+		t.Fatalf("expected a fully contained square not to Overlap (that's Contains)")
+	}
+}
+
+func TestAreaExpressionCrosses(t *testing.T) {
+	a := exprFor(square(0, 0, 1, 1))
+	// A vertical line entering through the bottom edge and leaving
+	// through the top: axis-aligned, so this is the case objectsCrosses
+	// can tell apart from Overlaps precisely.
+	through := line(geometry.Point{X: 0.5, Y: -1}, geometry.Point{X: 0.5, Y: 2})
+	if !a.Crosses(through) {
+		t.Fatalf("expected a line passing through the square's interior and out the other side to Cross")
+	}
+
+	// Entirely inside: Within, not Crosses.
+	inside := line(geometry.Point{X: 0.25, Y: 0.25}, geometry.Point{X: 0.75, Y: 0.75})
+	if a.Crosses(inside) {
+		t.Fatalf("expected a line fully contained in the square not to Cross")
+	}
+
+	// Entirely outside: Disjoint, not Crosses.
+	outside := line(geometry.Point{X: 10, Y: 10}, geometry.Point{X: 11, Y: 11})
+	if a.Crosses(outside) {
+		t.Fatalf("expected a disjoint line not to Cross")
+	}
+}
+
+func TestAreaExpressionCrossesDiagonalFallsBackToOverlaps(t *testing.T) {
+	a := exprFor(square(0, 0, 1, 1))
+	// A diagonal line isn't axis-aligned, so its Rect isn't degenerate and
+	// objectsCrosses has no way to tell this apart from objectsOverlaps --
+	// it degrades to reporting the same thing Overlaps would.
+	diagonal := line(geometry.Point{X: -1, Y: -1}, geometry.Point{X: 2, Y: 2})
+	if a.Crosses(diagonal) != a.Overlaps(diagonal) {
+		t.Fatalf("expected Crosses on a non-axis-aligned line to fall back to the Overlaps result")
+	}
+}
+
+func TestAreaExpressionTestNamed(t *testing.T) {
+	a := exprFor(square(0, 0, 1, 1))
+	adjacent := square(1, 0, 2, 1)
+
+	result, ok := a.TestNamed("TOUCHES", adjacent)
+	if !ok || !result {
+		t.Fatalf("expected TestNamed(\"TOUCHES\", ...) to dispatch to Touches and return true, got result=%v ok=%v", result, ok)
+	}
+	if _, ok := a.TestNamed("nearby", adjacent); ok {
+		t.Fatalf("expected TestNamed to report ok=false for a keyword that isn't an AREA predicate")
+	}
+	for token, fn := range AreaPredicates {
+		if got, _ := a.TestNamed(token, adjacent); got != fn(a, adjacent) {
+			t.Fatalf("TestNamed(%q, ...) disagreed with its mapped method", token)
+		}
+	}
+}
+
+func TestAreaExpressionNegationAndComposition(t *testing.T) {
+	a := exprFor(square(0, 0, 1, 1))
+	b := exprFor(square(10, 10, 11, 11))
+	and := &AreaExpression{op: AND, children: children{a, b}}
+	or := &AreaExpression{op: OR, children: children{a, b}}
+	target := square(0, 0, 1, 1)
+
+	if and.Disjoint(target) {
+		t.Fatalf("expected AND(a, b).Disjoint(a) to be false since a itself isn't disjoint")
+	}
+	if !or.Disjoint(target) {
+		t.Fatalf("expected OR(a, b).Disjoint(a) to be true since b is disjoint")
+	}
+
+	notTouches := &AreaExpression{negate: true, obj: a.obj}
+	adjacent := square(1, 0, 2, 1)
+	if notTouches.Touches(adjacent) {
+		t.Fatalf("expected NOT Touches to negate a true Touches result")
+	}
+}
+
+func TestAreaExpressionRectShortCircuitSkipsChildren(t *testing.T) {
+	a := exprFor(square(0, 0, 1, 1))
+	b := exprFor(square(10, 10, 11, 11))
+	and := &AreaExpression{op: AND, children: children{a, b}}
+	target := square(-5, -5, -4, -4) // outside the union Rect entirely
+
+	if and.Intersects(target) {
+		t.Fatalf("expected Intersects to be false for a target outside the union Rect")
+	}
+	explain := and.Explain()
+	for _, c := range explain.Children {
+		if c.Calls != 0 {
+			t.Fatalf("expected each child's Rect short-circuit to skip its predicate, got %d calls for %s", c.Calls, c.Desc)
+		}
+	}
+}
+
+func TestAreaExpressionWithinRectShortCircuit(t *testing.T) {
+	a := exprFor(square(0, 0, 1, 1))
+	tooSmall := square(0.25, 0.25, 0.75, 0.75) // doesn't fully contain a's Rect
+	bigEnough := square(-1, -1, 2, 2)
+	if a.Within(tooSmall) {
+		t.Fatalf("expected Within to be false when the query Rect doesn't contain the child Rect")
+	}
+	if !a.Within(bigEnough) {
+		t.Fatalf("expected Within to be true when the query Rect fully contains the child Rect")
+	}
+}
+
+func TestAreaExpressionANDOrdersSmallestRectFirst(t *testing.T) {
+	small := exprFor(square(0, 0, 1, 1))
+	large := exprFor(square(0, 0, 100, 100))
+	and := &AreaExpression{op: AND, children: children{large, small}}
+	ordered := and.orderedChildren()
+	if ordered[0] != small || ordered[1] != large {
+		t.Fatalf("expected AND to order the smallest-area child first")
+	}
+}
+
+func TestAreaExpressionORDersLargestRectFirst(t *testing.T) {
+	small := exprFor(square(0, 0, 1, 1))
+	large := exprFor(square(0, 0, 100, 100))
+	or := &AreaExpression{op: OR, children: children{small, large}}
+	ordered := or.orderedChildren()
+	if ordered[0] != large || ordered[1] != small {
+		t.Fatalf("expected OR to order the largest-area child first")
+	}
+}
+
+// buildScatteredANDTree builds an AND of n small, mutually disjoint
+// squares placed around the perimeter of a large bounding box, leaving
+// the center empty.
+func buildScatteredANDTree(n int) *AreaExpression {
+	cs := make(children, n)
+	for i := 0; i < n; i++ {
+		angle := float64(i) / float64(n) * 2 * math.Pi
+		cx := 1000 * math.Cos(angle)
+		cy := 1000 * math.Sin(angle)
+		cs[i] = exprFor(square(cx, cy, cx+1, cy+1))
+	}
+	return &AreaExpression{op: AND, children: cs}
+}
+
+func BenchmarkAreaExpressionIntersectsManyDisjointChildren(b *testing.B) {
+	expr := buildScatteredANDTree(500)
+	// Sits inside the union Rect (the perimeter of squares) but outside
+	// every individual child, so the per-child Rect short-circuit avoids
+	// the full geometry test per child.
+	target := square(-0.5, -0.5, 0.5, 0.5)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		expr.Intersects(target)
+	}
+}
+
+func BenchmarkAreaExpressionIntersectsOutsideUnionRect(b *testing.B) {
+	expr := buildScatteredANDTree(500)
+	// Entirely outside the union Rect, so every child's own Rect
+	// short-circuit rejects it without a full geometry test.
+	target := square(-5000, -5000, -4999, -4999)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		expr.Intersects(target)
+	}
+}